@@ -0,0 +1,136 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultQuantileEps is the rank-error bound used for every streaming
+// quantile summary kept by an Aggregator.
+const defaultQuantileEps = 0.01
+
+// summary accumulates the observations for a single Record-ed name
+// between flushes.
+type summary struct {
+	count  int
+	sum    float64
+	min    float64
+	max    float64
+	stream *quantileStream
+}
+
+// Aggregator wraps a Backend, maintaining per-name streaming summaries
+// of Record calls instead of forwarding every sample downstream. On
+// every FlushEvery it emits name.count, name.sum, name.min, name.max,
+// name.mean and one stat per configured quantile (eg name.p99), then
+// resets. Count calls pass straight through to the wrapped Backend.
+type Aggregator struct {
+	backend    Backend
+	flushEvery time.Duration
+	quantiles  []float64
+
+	mu        sync.Mutex
+	summaries map[string]*summary
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewAggregator creates an Aggregator that flushes derived stats to
+// backend every flushEvery, reporting the given quantiles (eg 0.5, 0.9,
+// 0.99 for p50/p90/p99).
+func NewAggregator(backend Backend, flushEvery time.Duration, quantiles []float64) *Aggregator {
+	a := &Aggregator{
+		backend:    backend,
+		flushEvery: flushEvery,
+		quantiles:  quantiles,
+		summaries:  make(map[string]*summary),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *Aggregator) Count(name string, count int) {
+	a.backend.Count(name, count)
+}
+
+func (a *Aggregator) Record(name string, value float64) {
+	a.mu.Lock()
+	s, ok := a.summaries[name]
+	if !ok {
+		s = &summary{min: value, max: value, stream: newQuantileStream(defaultQuantileEps)}
+		a.summaries[name] = s
+	}
+	s.count++
+	s.sum += value
+	if value < s.min {
+		s.min = value
+	}
+	if value > s.max {
+		s.max = value
+	}
+	s.stream.Insert(value)
+	a.mu.Unlock()
+}
+
+func (a *Aggregator) run() {
+	ticker := time.NewTicker(a.flushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.flush()
+		case <-a.stop:
+			a.flush()
+			close(a.stopped)
+			return
+		}
+	}
+}
+
+func (a *Aggregator) flush() {
+	a.mu.Lock()
+	summaries := a.summaries
+	a.summaries = make(map[string]*summary)
+	a.mu.Unlock()
+
+	for name, s := range summaries {
+		if s.count == 0 {
+			continue
+		}
+		a.backend.Count(name+".count", s.count)
+		a.backend.Record(name+".sum", s.sum)
+		a.backend.Record(name+".min", s.min)
+		a.backend.Record(name+".max", s.max)
+		a.backend.Record(name+".mean", s.sum/float64(s.count))
+		for _, q := range a.quantiles {
+			a.backend.Record(fmt.Sprintf("%s.p%s", name, quantileLabel(q)), s.stream.Query(q))
+		}
+	}
+}
+
+// quantileLabel renders q*100 as a stat name suffix with the decimal
+// point written as "_" (eg "99", "99_9"), keeping every significant
+// digit so distinct quantiles like 0.99 and 0.999 never collide on the
+// same "p99" name.
+func quantileLabel(q float64) string {
+	s := strconv.FormatFloat(q*100, 'f', -1, 64)
+	return strings.Replace(s, ".", "_", 1)
+}
+
+// Close stops the flush goroutine, flushing any pending summaries, then
+// closes the wrapped backend if it implements io.Closer.
+func (a *Aggregator) Close() error {
+	close(a.stop)
+	<-a.stopped
+	if closer, ok := a.backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+// Package influxbackend implements an InfluxDB backend for go.stats.
+package influxbackend
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type countPoint struct {
+	Name      string
+	Count     int
+	Timestamp int64 // unix nanoseconds
+}
+
+type valuePoint struct {
+	Name      string
+	Value     float64
+	Timestamp int64 // unix nanoseconds
+}
+
+// Influx is a stats.Backend that buffers Count/Record calls into InfluxDB
+// line protocol points and flushes them to an InfluxDB HTTP /write
+// endpoint in batches.
+type Influx struct {
+	URL                   string            // InfluxDB base URL, eg http://localhost:8086
+	Database              string            // database (v1) or bucket (v2)
+	Username              string            // basic auth username, v1 only
+	Password              string            // basic auth password, v1 only
+	Token                 string            // auth token, v2 only
+	Tags                  map[string]string // tags applied to every point
+	Debug                 bool              // enable logging of stat calls
+	DialTimeout           time.Duration     // timeout for net dial
+	ResponseHeaderTimeout time.Duration     // timeout for http read/write
+	MaxIdleConns          int               // max idle http connections
+	BatchTimeout          time.Duration     // timeout for batching stats
+	MaxBatchSize          int               // max items in a batch
+	ChannelSize           int               // buffer size until we begin blocking
+	stats                 chan interface{}  // countPoint or valuePoint
+	closed                chan error
+	client                *http.Client
+}
+
+func (in *Influx) Count(name string, count int) {
+	in.stats <- countPoint{Name: name, Count: count, Timestamp: time.Now().UnixNano()}
+}
+
+func (in *Influx) Record(name string, value float64) {
+	in.stats <- valuePoint{Name: name, Value: value, Timestamp: time.Now().UnixNano()}
+}
+
+func (in *Influx) Inc(name string) {
+	in.Count(name, 1)
+}
+
+// Actually send the points to InfluxDB.
+func (in *Influx) process() {
+	if in.Debug {
+		log.Println("influxbackend: started background process")
+	}
+
+	var batchTimeout <-chan time.Time
+	var batch []interface{}
+	for {
+		select {
+		case <-batchTimeout:
+			go in.sendBatchLog(batch)
+			batch = nil
+			batchTimeout = nil
+		case point, ok := <-in.stats:
+			if in.Debug {
+				if cp, ok := point.(countPoint); ok {
+					log.Printf("influxbackend: Count(%s, %d)", cp.Name, cp.Count)
+				}
+				if vp, ok := point.(valuePoint); ok {
+					log.Printf("influxbackend: Value(%s, %f)", vp.Name, vp.Value)
+				}
+			}
+			if !ok {
+				if in.Debug {
+					log.Println("influxbackend: process closed")
+				}
+				in.sendBatchLog(batch)
+				close(in.closed)
+				return
+			}
+			batch = append(batch, point)
+			if batchTimeout == nil {
+				batchTimeout = time.After(in.BatchTimeout)
+			}
+			if len(batch) >= in.MaxBatchSize {
+				go in.sendBatchLog(batch)
+				batch = nil
+				batchTimeout = nil
+			}
+		}
+	}
+}
+
+func (in *Influx) sendBatchLog(batch []interface{}) {
+	if err := in.sendBatch(batch); err != nil {
+		log.Println(err)
+	}
+}
+
+func (in *Influx) sendBatch(batch []interface{}) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if in.Debug {
+		log.Printf("influxbackend: sending batch with %d items", len(batch))
+	}
+
+	lines := make([]string, len(batch))
+	for i, point := range batch {
+		lines[i] = in.lineProtocol(point)
+	}
+	body := strings.Join(lines, "\n")
+
+	writeURL := fmt.Sprintf("%s/write?db=%s", strings.TrimRight(in.URL, "/"), in.Database)
+	req, err := http.NewRequest("POST", writeURL, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return fmt.Errorf("influxbackend: error creating http request: %s", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if in.Token != "" {
+		req.Header.Set("Authorization", "Token "+in.Token)
+	} else if in.Username != "" {
+		req.SetBasicAuth(in.Username, in.Password)
+	}
+
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("influxbackend: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("influxbackend: api error: status %d", resp.StatusCode)
+	} else if in.Debug {
+		log.Printf("influxbackend: api response status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// lineProtocol renders a single point in InfluxDB line protocol.
+func (in *Influx) lineProtocol(point interface{}) string {
+	switch p := point.(type) {
+	case countPoint:
+		return fmt.Sprintf(
+			"%s%s count=%di %d", sanitizeMeasurement(p.Name), in.tags(), p.Count, p.Timestamp)
+	case valuePoint:
+		return fmt.Sprintf(
+			"%s%s value=%s %d",
+			sanitizeMeasurement(p.Name),
+			in.tags(),
+			strconv.FormatFloat(p.Value, 'f', -1, 64),
+			p.Timestamp,
+		)
+	default:
+		return ""
+	}
+}
+
+// tags renders the configured global tags as a line protocol tag set,
+// including the leading comma, or the empty string if there are none.
+func (in *Influx) tags() string {
+	if len(in.Tags) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(in.Tags))
+	for k, v := range in.Tags {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(parts)
+	return "," + strings.Join(parts, ",")
+}
+
+// sanitizeMeasurement escapes characters that are significant in line
+// protocol (spaces and commas) in an otherwise free-form stat name.
+func sanitizeMeasurement(name string) string {
+	r := strings.NewReplacer(" ", "\\ ", ",", "\\,")
+	return r.Replace(name)
+}
+
+// Start the background goroutine for handling the actual HTTP requests.
+func (in *Influx) Start() {
+	in.stats = make(chan interface{}, in.ChannelSize)
+	in.closed = make(chan error)
+	in.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, in.DialTimeout)
+			},
+			ResponseHeaderTimeout: in.ResponseHeaderTimeout,
+			MaxIdleConnsPerHost:   in.MaxIdleConns,
+		},
+	}
+	go in.process()
+}
+
+// Close the background goroutine.
+func (in *Influx) Close() error {
+	close(in.stats)
+	return <-in.closed
+}
+
+// tagsFlag parses a "key=value,key=value" flag value into a map.
+type tagsFlag map[string]string
+
+func (t tagsFlag) String() string {
+	parts := make([]string, 0, len(t))
+	for k, v := range t {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (t tagsFlag) Set(s string) error {
+	for _, kv := range strings.Split(s, ",") {
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("influxbackend: invalid tag %q, expected key=value", kv)
+		}
+		t[parts[0]] = parts[1]
+	}
+	return nil
+}
+
+// A Flag configured Influx instance.
+func InfluxFlag(name string) *Influx {
+	in := &Influx{Tags: tagsFlag{}}
+	flag.StringVar(&in.URL, name+".url", "http://localhost:8086", name+" influxdb url")
+	flag.StringVar(&in.Database, name+".database", "", name+" influxdb database or bucket")
+	flag.StringVar(&in.Username, name+".username", "", name+" influxdb username")
+	flag.StringVar(&in.Password, name+".password", "", name+" influxdb password")
+	flag.StringVar(&in.Token, name+".token", "", name+" influxdb auth token")
+	flag.Var(tagsFlag(in.Tags), name+".tags", name+" global tags as key=value,key=value")
+	flag.BoolVar(&in.Debug, name+".debug", false, name+" debug logging")
+	flag.DurationVar(
+		&in.DialTimeout,
+		name+".http-dial-timeout",
+		1*time.Second,
+		name+" http dial timeout",
+	)
+	flag.DurationVar(
+		&in.ResponseHeaderTimeout,
+		name+".http-response-header-timeout",
+		3*time.Second,
+		name+" http response header timeout",
+	)
+	flag.IntVar(
+		&in.MaxIdleConns,
+		name+".max-idle-conns",
+		10,
+		name+" max idle connections to InfluxDB",
+	)
+	flag.DurationVar(
+		&in.BatchTimeout,
+		name+".batch-timeout",
+		10*time.Second,
+		name+" amount of time to aggregate a batch",
+	)
+	flag.IntVar(
+		&in.MaxBatchSize,
+		name+".max-batch-size",
+		500,
+		name+" maximum number of items in a batch",
+	)
+	flag.IntVar(
+		&in.ChannelSize,
+		name+".channel-buffer-size",
+		10000,
+		name+" channel buffer size",
+	)
+	return in
+}
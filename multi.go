@@ -0,0 +1,172 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// perBackendQueueSize bounds the number of in-flight goroutines
+// MultiBackend uses to fan out to a single backend, so one slow
+// backend can't cause unbounded goroutine growth. Each backend gets
+// its own queue so a stuck backend can't starve the others.
+const perBackendQueueSize = 16
+
+// backendEntry pairs a registered Backend with the bounded queue used
+// to dispatch calls to it in isolation from every other backend.
+type backendEntry struct {
+	backend Backend
+	sem     chan struct{}
+}
+
+func newBackendEntry(b Backend) *backendEntry {
+	return &backendEntry{backend: b, sem: make(chan struct{}, perBackendQueueSize)}
+}
+
+// MultiBackend fans out every Count/Record call to a set of backends, so
+// a single call site can ship stats to several sinks at once. Backends
+// can be added or removed at runtime, and a slow or panicking backend
+// does not block or break the others.
+type MultiBackend struct {
+	mu       sync.RWMutex
+	backends []*backendEntry
+}
+
+// NewMultiBackend creates a MultiBackend dispatching to the given
+// backends.
+func NewMultiBackend(backends ...Backend) *MultiBackend {
+	m := &MultiBackend{}
+	for _, b := range backends {
+		m.backends = append(m.backends, newBackendEntry(b))
+	}
+	return m
+}
+
+// Add registers an additional backend.
+func (m *MultiBackend) Add(b Backend) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.backends = append(m.backends, newBackendEntry(b))
+}
+
+// Remove unregisters a backend previously passed to NewMultiBackend or
+// Add.
+func (m *MultiBackend) Remove(b Backend) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.backends {
+		if e.backend == b {
+			m.backends = append(m.backends[:i], m.backends[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *MultiBackend) Count(name string, count int) {
+	for _, e := range m.snapshot() {
+		e := e
+		e.dispatch(func() {
+			defer recoverPanic("Count", e.backend)
+			e.backend.Count(name, count)
+		})
+	}
+}
+
+func (m *MultiBackend) Record(name string, value float64) {
+	for _, e := range m.snapshot() {
+		e := e
+		e.dispatch(func() {
+			defer recoverPanic("Record", e.backend)
+			e.backend.Record(name, value)
+		})
+	}
+}
+
+func (m *MultiBackend) snapshot() []*backendEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	backends := make([]*backendEntry, len(m.backends))
+	copy(backends, m.backends)
+	return backends
+}
+
+// dispatch runs fn in its own goroutine, bounded by e.sem so a burst of
+// calls to this backend can't spawn unlimited goroutines. If the queue
+// is already full the call is dropped and logged rather than blocking
+// the caller, so a stuck backend can't stall Count/Record for every
+// other registered backend.
+func (e *backendEntry) dispatch(fn func()) {
+	select {
+	case e.sem <- struct{}{}:
+		go func() {
+			defer func() { <-e.sem }()
+			fn()
+		}()
+	default:
+		log.Printf("stats: backend %T queue full, dropping call", e.backend)
+	}
+}
+
+func recoverPanic(op string, b Backend) {
+	if r := recover(); r != nil {
+		log.Printf("stats: backend %T panicked in %s: %v", b, op, r)
+	}
+}
+
+// Close closes every backend that implements io.Closer and joins any
+// errors encountered.
+func (m *MultiBackend) Close() error {
+	entries := m.snapshot()
+	errs := make(chan error, len(entries))
+	var wg sync.WaitGroup
+	for _, e := range entries {
+		closer, ok := e.backend.(io.Closer)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(c io.Closer) {
+			defer wg.Done()
+			errs <- c.Close()
+		}(closer)
+	}
+	wg.Wait()
+	close(errs)
+
+	var msgs []string
+	for err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("stats: errors closing backends: %s", strings.Join(msgs, "; "))
+}
+
+// NopBackend discards every Count/Record call. It is useful in tests and
+// small programs that want a Backend without depending on stathat.
+type NopBackend struct{}
+
+func (NopBackend) Count(name string, count int)      {}
+func (NopBackend) Record(name string, value float64) {}
+
+type logBackend struct {
+	logger *log.Logger
+}
+
+// LogBackend returns a Backend that logs every Count/Record call to l.
+func LogBackend(l *log.Logger) Backend {
+	return &logBackend{logger: l}
+}
+
+func (b *logBackend) Count(name string, count int) {
+	b.logger.Printf("stats.Count(%s, %d)", name, count)
+}
+
+func (b *logBackend) Record(name string, value float64) {
+	b.logger.Printf("stats.Record(%s, %f)", name, value)
+}
@@ -0,0 +1,133 @@
+package stats
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingBackend struct {
+	count int64
+}
+
+func (b *countingBackend) Count(name string, n int)     { atomic.AddInt64(&b.count, int64(n)) }
+func (b *countingBackend) Record(name string, v float64) {}
+
+type panicBackend struct{}
+
+func (panicBackend) Count(name string, count int)      { panic("boom") }
+func (panicBackend) Record(name string, value float64) { panic("boom") }
+
+type blockingBackend struct {
+	unblock chan struct{}
+}
+
+func (b *blockingBackend) Count(name string, count int) { <-b.unblock }
+func (b *blockingBackend) Record(name string, value float64) {}
+
+func TestMultiBackendFanOut(t *testing.T) {
+	a := &countingBackend{}
+	b := &countingBackend{}
+	m := NewMultiBackend(a, b)
+
+	for i := 0; i < 10; i++ {
+		m.Count("some.stat", 1)
+	}
+
+	deadline := time.After(time.Second)
+	for atomic.LoadInt64(&a.count) < 10 || atomic.LoadInt64(&b.count) < 10 {
+		select {
+		case <-deadline:
+			t.Fatalf("backends saw a=%d b=%d, want 10 each", a.count, b.count)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestMultiBackendAddRemove(t *testing.T) {
+	a := &countingBackend{}
+	m := NewMultiBackend()
+	m.Add(a)
+	m.Count("some.stat", 1)
+	waitForCount(t, &a.count, 1)
+
+	m.Remove(a)
+	m.Count("some.stat", 1)
+	time.Sleep(10 * time.Millisecond)
+	if got := atomic.LoadInt64(&a.count); got != 1 {
+		t.Fatalf("count after Remove = %d, want 1 (no further delivery)", got)
+	}
+}
+
+func TestMultiBackendIsolatesPanickingBackend(t *testing.T) {
+	a := &countingBackend{}
+	m := NewMultiBackend(panicBackend{}, a)
+	m.Count("some.stat", 1)
+	waitForCount(t, &a.count, 1)
+}
+
+func TestMultiBackendIsolatesSlowBackend(t *testing.T) {
+	blocker := &blockingBackend{unblock: make(chan struct{})}
+	defer close(blocker.unblock)
+	a := &countingBackend{}
+	m := NewMultiBackend(blocker, a)
+
+	// blocker's Count does not return until the test ends, so every call
+	// to it holds a slot in its queue -- but that must never stop a's
+	// calls from being dispatched and delivered.
+	const calls = 3
+	for i := 0; i < calls; i++ {
+		m.Count("some.stat", 1)
+	}
+	waitForCount(t, &a.count, calls)
+}
+
+func waitForCount(t *testing.T, counter *int64, want int64) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		if atomic.LoadInt64(counter) >= want {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("counter reached %d, want %d", atomic.LoadInt64(counter), want)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestMultiBackendClose(t *testing.T) {
+	var mu sync.Mutex
+	var closed []string
+
+	m := NewMultiBackend(
+		closerBackend{name: "a", closed: &closed, mu: &mu},
+		closerBackend{name: "b", closed: &closed, mu: &mu},
+		NopBackend{},
+	)
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() = %v, want nil", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(closed) != 2 {
+		t.Fatalf("closed = %v, want 2 backends closed", closed)
+	}
+}
+
+type closerBackend struct {
+	name   string
+	closed *[]string
+	mu     *sync.Mutex
+}
+
+func (closerBackend) Count(name string, count int)      {}
+func (closerBackend) Record(name string, value float64) {}
+func (c closerBackend) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.closed = append(*c.closed, c.name)
+	return nil
+}
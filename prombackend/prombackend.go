@@ -0,0 +1,185 @@
+// Package prombackend implements a Prometheus-scrape backend for
+// go.stats. Instead of pushing stats to a remote service, it keeps
+// in-process counters and gauges and exposes them over HTTP in
+// Prometheus text exposition format for a scraper to pull.
+package prombackend
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	sanitizeRe = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	labelRe    = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+type counterEntry struct {
+	name   string
+	labels string
+	value  int64 // atomic
+}
+
+type gaugeEntry struct {
+	name   string
+	labels string
+	bits   uint64 // atomic, math.Float64bits of the last recorded value
+}
+
+// Prom is a stats.Backend that keeps in-process counters (from Count)
+// and gauges (from Record), exposing them at Handler() in Prometheus
+// text exposition format. It is safe for high-concurrency Count/Record
+// calls since these are on the hot path of every stat call.
+type Prom struct {
+	Namespace string // optional prefix applied to every metric name
+	Debug     bool   // has no effect: Prom does not log stat calls
+
+	counters sync.Map // key -> *counterEntry
+	gauges   sync.Map // key -> *gaugeEntry
+}
+
+func (p *Prom) Count(name string, count int) {
+	base, labels := parseName(name)
+	key := base + renderLabels(labels)
+	v, _ := p.counters.LoadOrStore(key, &counterEntry{
+		name:   p.metricName(base),
+		labels: renderLabels(labels),
+	})
+	atomic.AddInt64(&v.(*counterEntry).value, int64(count))
+}
+
+func (p *Prom) Record(name string, value float64) {
+	base, labels := parseName(name)
+	key := base + renderLabels(labels)
+	v, _ := p.gauges.LoadOrStore(key, &gaugeEntry{
+		name:   p.metricName(base),
+		labels: renderLabels(labels),
+	})
+	atomic.StoreUint64(&v.(*gaugeEntry).bits, math.Float64bits(value))
+}
+
+func (p *Prom) Inc(name string) {
+	p.Count(name, 1)
+}
+
+// metricName sanitizes base (replacing anything but [a-zA-Z0-9_] with
+// "_") and applies the configured Namespace prefix, if any.
+func (p *Prom) metricName(base string) string {
+	sanitized := sanitizeRe.ReplaceAllString(base, "_")
+	if p.Namespace == "" {
+		return sanitized
+	}
+	return sanitizeRe.ReplaceAllString(p.Namespace, "_") + "_" + sanitized
+}
+
+// parseName splits a name like `http.requests{route="/x",code="200"}`
+// into its base name and label set, so existing dot-namespaced call
+// sites can be gradually migrated to carry labels.
+func parseName(name string) (base string, labels map[string]string) {
+	i := strings.IndexByte(name, '{')
+	if i < 0 || !strings.HasSuffix(name, "}") {
+		return name, nil
+	}
+	base = name[:i]
+	for _, m := range labelRe.FindAllStringSubmatch(name[i+1:len(name)-1], -1) {
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[m[1]] = m[2]
+	}
+	return base, labels
+}
+
+// renderLabels renders labels in Prometheus exposition format, e.g.
+// `{route="/x",code="200"}`, or the empty string if there are none.
+func renderLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for k, v := range labels {
+		parts = append(parts, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(parts)
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Handler returns an http.Handler that exposes every counter and gauge
+// in Prometheus text exposition format.
+func (p *Prom) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		p.writeCounters(w)
+		p.writeGauges(w)
+	})
+}
+
+// writeCounters groups the counters by metric name, so a single
+// "# TYPE" / "# HELP" header is emitted per name, then writes one line
+// per label-set in sorted order for deterministic output.
+func (p *Prom) writeCounters(w io.Writer) {
+	byName := make(map[string][]*counterEntry)
+	p.counters.Range(func(_, value interface{}) bool {
+		e := value.(*counterEntry)
+		byName[e.name] = append(byName[e.name], e)
+		return true
+	})
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "# HELP %s %s counter\n", name, name)
+		fmt.Fprintf(w, "# TYPE %s counter\n", name)
+		entries := byName[name]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].labels < entries[j].labels })
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s%s %d\n", e.name, e.labels, atomic.LoadInt64(&e.value))
+		}
+	}
+}
+
+// writeGauges is the gauge equivalent of writeCounters.
+func (p *Prom) writeGauges(w io.Writer) {
+	byName := make(map[string][]*gaugeEntry)
+	p.gauges.Range(func(_, value interface{}) bool {
+		e := value.(*gaugeEntry)
+		byName[e.name] = append(byName[e.name], e)
+		return true
+	})
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "# HELP %s %s gauge\n", name, name)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		entries := byName[name]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].labels < entries[j].labels })
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s%s %g\n", e.name, e.labels, math.Float64frombits(atomic.LoadUint64(&e.bits)))
+		}
+	}
+}
+
+// NewFromFlags returns a Flag configured Prom instance.
+func NewFromFlags(name string) *Prom {
+	p := &Prom{}
+	flag.StringVar(&p.Namespace, name+".namespace", "", name+" metric name prefix")
+	flag.BoolVar(&p.Debug, name+".debug", false, name+" debug logging (has no effect)")
+	return p
+}
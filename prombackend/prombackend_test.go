@@ -0,0 +1,87 @@
+package prombackend
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseName(t *testing.T) {
+	cases := []struct {
+		name       string
+		wantBase   string
+		wantLabels map[string]string
+	}{
+		{"http.requests", "http.requests", nil},
+		{`http.requests{route="/x",code="200"}`, "http.requests", map[string]string{"route": "/x", "code": "200"}},
+		{`solo{k="v"}`, "solo", map[string]string{"k": "v"}},
+	}
+	for _, c := range cases {
+		base, labels := parseName(c.name)
+		if base != c.wantBase {
+			t.Errorf("parseName(%q) base = %q, want %q", c.name, base, c.wantBase)
+		}
+		if len(labels) != len(c.wantLabels) {
+			t.Errorf("parseName(%q) labels = %v, want %v", c.name, labels, c.wantLabels)
+			continue
+		}
+		for k, v := range c.wantLabels {
+			if labels[k] != v {
+				t.Errorf("parseName(%q) labels[%q] = %q, want %q", c.name, k, labels[k], v)
+			}
+		}
+	}
+}
+
+func TestMetricNameSanitizesNamespaceAndBase(t *testing.T) {
+	p := &Prom{Namespace: "my-service"}
+	got := p.metricName("http.requests")
+	want := "my_service_http_requests"
+	if got != want {
+		t.Errorf("metricName = %q, want %q", got, want)
+	}
+}
+
+func TestMetricNameNoNamespace(t *testing.T) {
+	p := &Prom{}
+	if got := p.metricName("http.requests"); got != "http_requests" {
+		t.Errorf("metricName = %q, want http_requests", got)
+	}
+}
+
+func TestHandlerExposesCountersAndGauges(t *testing.T) {
+	p := &Prom{}
+	p.Count("http.requests", 3)
+	p.Count("http.requests", 2)
+	p.Record("latency.ms", 42.5)
+
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	if !strings.Contains(body, "# TYPE http_requests counter") {
+		t.Errorf("body missing counter TYPE line:\n%s", body)
+	}
+	if !strings.Contains(body, "http_requests 5") {
+		t.Errorf("body missing accumulated counter value:\n%s", body)
+	}
+	if !strings.Contains(body, "# TYPE latency_ms gauge") {
+		t.Errorf("body missing gauge TYPE line:\n%s", body)
+	}
+	if !strings.Contains(body, "latency_ms 42.5") {
+		t.Errorf("body missing gauge value:\n%s", body)
+	}
+}
+
+func TestHandlerRendersLabels(t *testing.T) {
+	p := &Prom{}
+	p.Count(`http.requests{route="/x",code="200"}`, 1)
+
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	if !strings.Contains(body, `http_requests{code="200",route="/x"} 1`) {
+		t.Errorf("body missing labeled series:\n%s", body)
+	}
+}
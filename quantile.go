@@ -0,0 +1,127 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// compressInterval is how many inserts accumulate between compressions of
+// a quantileStream, amortizing the cost of compress across many inserts.
+const compressInterval = 128
+
+// quantileSample is one (value, g, delta) tuple in the Cormode-Korn-
+// Muthukrishnan-Srivastava biased quantile summary: g is the number of
+// observations covered since the previous tuple, and delta is the
+// allowed rank error for this tuple.
+type quantileSample struct {
+	value float64
+	g     int
+	delta int
+}
+
+// quantileStream maintains a biased quantile summary of a stream of
+// float64 observations, as described in "Effective Computation of
+// Biased Quantiles over Data Streams" (Cormode, Korn, Muthukrishnan,
+// Srivastava). It supports querying any quantile within eps of the true
+// rank using space sub-linear in the number of observations.
+type quantileStream struct {
+	eps     float64
+	samples []quantileSample
+	n       int
+}
+
+func newQuantileStream(eps float64) *quantileStream {
+	return &quantileStream{eps: eps}
+}
+
+// Insert adds v to the stream.
+func (s *quantileStream) Insert(v float64) {
+	s.insert(v)
+	s.n++
+	if s.n%compressInterval == 0 {
+		s.compress()
+	}
+}
+
+func (s *quantileStream) insert(v float64) {
+	i := sort.Search(len(s.samples), func(i int) bool {
+		return s.samples[i].value >= v
+	})
+
+	var delta int
+	if i != 0 && i != len(s.samples) {
+		delta = int(math.Floor(2 * s.eps * float64(s.rankBefore(i))))
+	}
+
+	s.samples = append(s.samples, quantileSample{})
+	copy(s.samples[i+1:], s.samples[i:])
+	s.samples[i] = quantileSample{value: v, g: 1, delta: delta}
+}
+
+// rankBefore returns the rank of the tuple that would sit at index i,
+// i.e. the sum of g for every tuple before it.
+func (s *quantileStream) rankBefore(i int) int {
+	r := 0
+	for j := 0; j < i; j++ {
+		r += s.samples[j].g
+	}
+	return r
+}
+
+// compress merges adjacent tuples that can be combined without
+// violating the rank-error invariant, keeping the summary small.
+func (s *quantileStream) compress() {
+	if len(s.samples) < 3 {
+		return
+	}
+
+	merged := make([]quantileSample, 0, len(s.samples))
+	merged = append(merged, s.samples[0])
+	r := s.samples[0].g
+	for i := 1; i < len(s.samples)-1; i++ {
+		cur := s.samples[i]
+		next := s.samples[i+1]
+		threshold := int(math.Floor(2 * s.eps * float64(r+cur.g)))
+		if cur.g+next.g+next.delta <= threshold {
+			s.samples[i+1].g += cur.g
+			r += cur.g
+			continue
+		}
+		merged = append(merged, cur)
+		r += cur.g
+	}
+	merged = append(merged, s.samples[len(s.samples)-1])
+	s.samples = merged
+}
+
+// Query returns the value at quantile q (0 <= q <= 1), accurate to
+// within eps of the true rank.
+func (s *quantileStream) Query(q float64) float64 {
+	switch len(s.samples) {
+	case 0:
+		return 0
+	case 1:
+		return s.samples[0].value
+	}
+
+	rank := int(math.Ceil(q * float64(s.n)))
+	threshold := float64(rank) + math.Floor(2*s.eps*float64(s.n))/2
+
+	r := 0
+	for i, sample := range s.samples {
+		r += sample.g
+		if float64(r+sample.delta) > threshold {
+			if i == 0 {
+				return sample.value
+			}
+			return s.samples[i-1].value
+		}
+	}
+	return s.samples[len(s.samples)-1].value
+}
+
+// Reset discards every observation, returning the stream to empty.
+func (s *quantileStream) Reset() {
+	s.samples = nil
+	s.n = 0
+}
@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestQuantileStreamUniform(t *testing.T) {
+	const n = 10000
+	const eps = 0.01
+
+	r := rand.New(rand.NewSource(1))
+	values := make([]float64, n)
+	s := newQuantileStream(eps)
+	for i := range values {
+		v := r.Float64() * 1000
+		values[i] = v
+		s.Insert(v)
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		got := s.Query(q)
+		wantRank := int(math.Ceil(q * n))
+		want := values[wantRank-1]
+		maxErr := eps * n
+		if diff := math.Abs(rankOf(values, got) - float64(wantRank)); diff > maxErr {
+			t.Errorf("q=%v: got %v (rank %v), want value near %v (rank %v), rank error %v exceeds %v",
+				q, got, rankOf(values, got), want, wantRank, diff, maxErr)
+		}
+	}
+}
+
+func TestQuantileStreamEmpty(t *testing.T) {
+	s := newQuantileStream(0.01)
+	if got := s.Query(0.5); got != 0 {
+		t.Errorf("Query on empty stream = %v, want 0", got)
+	}
+}
+
+func TestQuantileStreamSingle(t *testing.T) {
+	s := newQuantileStream(0.01)
+	s.Insert(42)
+	if got := s.Query(0.5); got != 42 {
+		t.Errorf("Query on single-value stream = %v, want 42", got)
+	}
+}
+
+func TestQuantileStreamReset(t *testing.T) {
+	s := newQuantileStream(0.01)
+	for i := 0; i < 100; i++ {
+		s.Insert(float64(i))
+	}
+	s.Reset()
+	if got := s.Query(0.5); got != 0 {
+		t.Errorf("Query after Reset = %v, want 0", got)
+	}
+	if s.n != 0 || len(s.samples) != 0 {
+		t.Errorf("Reset left n=%d len(samples)=%d, want both 0", s.n, len(s.samples))
+	}
+}
+
+// rankOf returns the rank (1-based, first index whose value matches)
+// of v within the sorted slice sorted.
+func rankOf(sorted []float64, v float64) float64 {
+	i := sort.SearchFloat64s(sorted, v)
+	return float64(i + 1)
+}
+
+func TestQuantileLabel(t *testing.T) {
+	cases := []struct {
+		q    float64
+		want string
+	}{
+		{0.5, "50"},
+		{0.9, "90"},
+		{0.99, "99"},
+		{0.999, "99_9"},
+	}
+	for _, c := range cases {
+		if got := quantileLabel(c.q); got != c.want {
+			t.Errorf("quantileLabel(%v) = %q, want %q", c.q, got, c.want)
+		}
+	}
+	if quantileLabel(0.99) == quantileLabel(0.999) {
+		t.Errorf("quantileLabel(0.99) and quantileLabel(0.999) collide: %q", quantileLabel(0.99))
+	}
+}
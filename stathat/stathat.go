@@ -2,25 +2,34 @@
 package stathat
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/daaku/go.jsonpipe"
+	"github.com/daaku/go.stats"
 )
 
 type countStat struct {
-	Name  string `json:"stat"`
-	Count int    `json:"count"`
+	Name      string `json:"stat"`
+	Count     int    `json:"count"`
+	Timestamp int64  `json:"t,omitempty"`
 }
 
 type valueStat struct {
-	Name  string  `json:"stat"`
-	Value float64 `json:"value"`
+	Name      string  `json:"stat"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"t,omitempty"`
 }
 
 type apiRequest struct {
@@ -35,15 +44,43 @@ type apiResponse struct {
 }
 
 type EZKey struct {
-	Key          string        // your StatHat EZ Key
-	Debug        bool          // enable logging of stat calls
-	BatchTimeout time.Duration // timeout for batching stats
-	MaxBatchSize int           // max items in a batch
-	ChannelSize  int           // buffer size until we begin blocking
-	Transport    http.RoundTripper
-	stats        chan interface{} // countStat or valueStat
-	closed       chan error
-	startOnce    sync.Once
+	Key                  string        // your StatHat EZ Key
+	Debug                bool          // enable logging of stat calls
+	BatchTimeout         time.Duration // timeout for batching stats
+	MaxBatchSize         int           // max items in a batch
+	ChannelSize          int           // buffer size until we begin blocking
+	RetryInitialInterval time.Duration // initial delay before the first retry
+	RetryMaxInterval     time.Duration // cap on the backoff delay between retries
+	RetryMaxElapsed      time.Duration // give up retrying a batch after this long
+	RetryQueueSize       int           // max batches held for retry before dropping
+	Transport            http.RoundTripper
+	stats                chan interface{} // countStat or valueStat
+	closed               chan error
+	flush                chan chan error
+	retryQueue           chan *apiRequest
+	startOnce            sync.Once
+	batches              int64 // atomic, count of batches sent successfully
+	retries              int64 // atomic, count of retry attempts
+	dropped              int64 // atomic, count of batches dropped from a full retry queue
+}
+
+// Metrics returns the number of batches sent successfully, the number
+// of retry attempts made, and the number of batches dropped because
+// the retry queue was full. Safe to call concurrently.
+func (e *EZKey) Metrics() (batches, retries, dropped int64) {
+	return atomic.LoadInt64(&e.batches),
+		atomic.LoadInt64(&e.retries),
+		atomic.LoadInt64(&e.dropped)
+}
+
+// reportCount mirrors a local counter into the stats pipeline under
+// name, but only if a backend has been configured: calling stats.Count
+// with none set panics, and EZKey is commonly used standalone or wired
+// into a MultiBackend without also being the global backend.
+func reportCount(name string, count int) {
+	if stats.Enabled() {
+		stats.Count(name, count)
+	}
 }
 
 func (e *EZKey) Count(name string, count int) {
@@ -51,11 +88,25 @@ func (e *EZKey) Count(name string, count int) {
 	e.stats <- countStat{Name: name, Count: count}
 }
 
+// CountAt is like Count but records the time the event actually happened,
+// instead of the time the batch containing it is flushed.
+func (e *EZKey) CountAt(name string, count int, t time.Time) {
+	e.startOnce.Do(e.start)
+	e.stats <- countStat{Name: name, Count: count, Timestamp: t.Unix()}
+}
+
 func (e *EZKey) Record(name string, value float64) {
 	e.startOnce.Do(e.start)
 	e.stats <- valueStat{Name: name, Value: value}
 }
 
+// RecordAt is like Record but records the time the event actually happened,
+// instead of the time the batch containing it is flushed.
+func (e *EZKey) RecordAt(name string, value float64, t time.Time) {
+	e.startOnce.Do(e.start)
+	e.stats <- valueStat{Name: name, Value: value, Timestamp: t.Unix()}
+}
+
 func (e *EZKey) Inc(name string) {
 	e.Count(name, 1)
 }
@@ -66,6 +117,14 @@ func (e *EZKey) process() {
 		log.Println("stathatbackend: started background process")
 	}
 
+	// drainRetries resends the batches waiting in the retry queue; it is
+	// called each time process flushes a batch.
+	drainRetries := func() {
+		for n := len(e.retryQueue); n > 0; n-- {
+			go e.sendBatchLog(<-e.retryQueue)
+		}
+	}
+
 	var batchTimeout <-chan time.Time
 	batch := &apiRequest{EZKey: e.Key}
 	for {
@@ -95,21 +154,93 @@ func (e *EZKey) process() {
 				go e.sendBatchLog(batch)
 				batch = &apiRequest{EZKey: e.Key}
 				batchTimeout = nil
+				drainRetries()
 			}
 		case <-batchTimeout:
 			go e.sendBatchLog(batch)
 			batch = &apiRequest{EZKey: e.Key}
 			batchTimeout = nil
+			drainRetries()
+		case reply := <-e.flush:
+			go e.sendBatchFlush(batch, reply)
+			batch = &apiRequest{EZKey: e.Key}
+			batchTimeout = nil
+			drainRetries()
 		}
 	}
 }
 
 func (e *EZKey) sendBatchLog(batch *apiRequest) {
-	if err := e.sendBatch(batch); err != nil {
+	if err := e.sendBatchWithRetry(batch); err != nil {
 		log.Println(err)
+		e.enqueueRetry(batch)
+	}
+}
+
+// sendBatchFlush is like sendBatchLog, but also reports the final
+// error back to a Flush caller. It runs in its own goroutine so a
+// stuck send can't block process from handling other stats while a
+// flush is in progress.
+func (e *EZKey) sendBatchFlush(batch *apiRequest, reply chan<- error) {
+	err := e.sendBatchWithRetry(batch)
+	if err != nil {
+		log.Println(err)
+		e.enqueueRetry(batch)
+	}
+	reply <- err
+}
+
+// sendBatchWithRetry sends batch, retrying with exponential backoff and
+// jitter until it succeeds or RetryMaxElapsed has passed.
+func (e *EZKey) sendBatchWithRetry(batch *apiRequest) error {
+	if len(batch.Data) == 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(e.RetryMaxElapsed)
+	interval := e.RetryInitialInterval
+	for {
+		err := e.sendBatch(batch)
+		if err == nil {
+			atomic.AddInt64(&e.batches, 1)
+			reportCount("stathat.batches", 1)
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return err
+		}
+		atomic.AddInt64(&e.retries, 1)
+		reportCount("stathat.retries", 1)
+		time.Sleep(jitter(interval))
+		interval *= 2
+		if interval > e.RetryMaxInterval {
+			interval = e.RetryMaxInterval
+		}
 	}
 }
 
+// enqueueRetry pushes batch onto the bounded retry queue, to be
+// re-drained by process on its next flush. If the queue is full the
+// batch is dropped.
+func (e *EZKey) enqueueRetry(batch *apiRequest) {
+	select {
+	case e.retryQueue <- batch:
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+		reportCount("stathat.dropped", 1)
+		log.Println("stathatbackend: retry queue full, dropping batch")
+	}
+}
+
+// jitter returns a random duration between d/2 and d, so many clients
+// retrying at once don't all hammer the API in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
 func (e *EZKey) sendBatch(batch *apiRequest) error {
 	if len(batch.Data) == 0 {
 		return nil
@@ -147,6 +278,8 @@ func (e *EZKey) sendBatch(batch *apiRequest) error {
 func (e *EZKey) start() {
 	e.stats = make(chan interface{}, e.ChannelSize)
 	e.closed = make(chan error)
+	e.flush = make(chan chan error)
+	e.retryQueue = make(chan *apiRequest, e.RetryQueueSize)
 	go e.process()
 }
 
@@ -156,6 +289,25 @@ func (e *EZKey) Close() error {
 	return <-e.closed
 }
 
+// Flush forces an immediate send of the currently batched stats and
+// blocks until it completes or ctx is done. Useful for graceful
+// shutdown paths beyond Close.
+func (e *EZKey) Flush(ctx context.Context) error {
+	e.startOnce.Do(e.start)
+	reply := make(chan error, 1)
+	select {
+	case e.flush <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // A Flag configured EZKey instance.
 func EZKeyFlag(name string) *EZKey {
 	e := &EZKey{}
@@ -179,5 +331,223 @@ func EZKeyFlag(name string) *EZKey {
 		10000,
 		name+" channel buffer size",
 	)
+	flag.DurationVar(
+		&e.RetryInitialInterval,
+		name+".retry-initial-interval",
+		1*time.Second,
+		name+" initial delay before retrying a failed batch",
+	)
+	flag.DurationVar(
+		&e.RetryMaxInterval,
+		name+".retry-max-interval",
+		30*time.Second,
+		name+" maximum delay between retries of a failed batch",
+	)
+	flag.DurationVar(
+		&e.RetryMaxElapsed,
+		name+".retry-max-elapsed",
+		5*time.Minute,
+		name+" stop retrying a batch after this long and queue it",
+	)
+	flag.IntVar(
+		&e.RetryQueueSize,
+		name+".retry-queue-size",
+		100,
+		name+" maximum number of batches held for retry before dropping",
+	)
 	return e
 }
+
+// Classic implements the StatHat "classic" API, where each stat is
+// identified by its own per-stat key rather than grouped under a single
+// EZ key. StatKeys maps the names used in Count/Record calls to the
+// corresponding StatHat stat key.
+type Classic struct {
+	UserKey      string            // your StatHat user key
+	StatKeys     map[string]string // stat name -> StatHat stat key
+	Debug        bool              // enable logging of stat calls
+	BatchTimeout time.Duration     // timeout for batching stats
+	MaxBatchSize int               // max items in a batch
+	ChannelSize  int               // buffer size until we begin blocking
+	Transport    http.RoundTripper
+	stats        chan interface{} // countStat or valueStat
+	closed       chan error
+	startOnce    sync.Once
+}
+
+func (c *Classic) Count(name string, count int) {
+	c.CountAt(name, count, time.Now())
+}
+
+// CountAt is like Count but records the time the event actually happened,
+// instead of the time the batch containing it is flushed.
+func (c *Classic) CountAt(name string, count int, t time.Time) {
+	c.startOnce.Do(c.start)
+	c.stats <- countStat{Name: name, Count: count, Timestamp: t.Unix()}
+}
+
+func (c *Classic) Record(name string, value float64) {
+	c.RecordAt(name, value, time.Now())
+}
+
+// RecordAt is like Record but records the time the event actually happened,
+// instead of the time the batch containing it is flushed.
+func (c *Classic) RecordAt(name string, value float64, t time.Time) {
+	c.startOnce.Do(c.start)
+	c.stats <- valueStat{Name: name, Value: value, Timestamp: t.Unix()}
+}
+
+func (c *Classic) Inc(name string) {
+	c.Count(name, 1)
+}
+
+// Actually send the stats to stathat, reusing the same batching,
+// goroutine and backpressure machinery as EZKey.
+func (c *Classic) process() {
+	if c.Debug {
+		log.Println("stathat: classic started background process")
+	}
+
+	var batchTimeout <-chan time.Time
+	var batch []interface{}
+	for {
+		select {
+		case stat, open := <-c.stats:
+			if c.Debug {
+				if cs, ok := stat.(countStat); ok {
+					log.Printf("stathat: classic Count(%s, %d)", cs.Name, cs.Count)
+				}
+				if vs, ok := stat.(valueStat); ok {
+					log.Printf("stathat: classic Value(%s, %f)", vs.Name, vs.Value)
+				}
+			}
+			if !open {
+				if c.Debug {
+					log.Println("stathat: classic process closed")
+				}
+				c.sendBatchLog(batch)
+				close(c.closed)
+				return
+			}
+			batch = append(batch, stat)
+			if batchTimeout == nil {
+				batchTimeout = time.After(c.BatchTimeout)
+			}
+			if len(batch) >= c.MaxBatchSize {
+				go c.sendBatchLog(batch)
+				batch = nil
+				batchTimeout = nil
+			}
+		case <-batchTimeout:
+			go c.sendBatchLog(batch)
+			batch = nil
+			batchTimeout = nil
+		}
+	}
+}
+
+func (c *Classic) sendBatchLog(batch []interface{}) {
+	if err := c.sendBatch(batch); err != nil {
+		log.Println(err)
+	}
+}
+
+func (c *Classic) sendBatch(batch []interface{}) error {
+	if c.Debug {
+		log.Printf("stathat: classic sending batch with %d items", len(batch))
+	}
+	for _, stat := range batch {
+		if err := c.sendOne(stat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendOne posts a single classic data point to the /c (count) or /v
+// (value) endpoint using URL-encoded params, as the classic API has no
+// batch endpoint of its own.
+func (c *Classic) sendOne(stat interface{}) error {
+	values := url.Values{}
+	values.Set("ukey", c.UserKey)
+
+	var endpoint string
+	switch s := stat.(type) {
+	case countStat:
+		endpoint = "http://api.stathat.com/c"
+		values.Set("key", c.StatKeys[s.Name])
+		values.Set("count", strconv.Itoa(s.Count))
+		if s.Timestamp != 0 {
+			values.Set("t", strconv.FormatInt(s.Timestamp, 10))
+		}
+	case valueStat:
+		endpoint = "http://api.stathat.com/v"
+		values.Set("key", c.StatKeys[s.Name])
+		values.Set("value", strconv.FormatFloat(s.Value, 'f', -1, 64))
+		if s.Timestamp != 0 {
+			values.Set("t", strconv.FormatInt(s.Timestamp, 10))
+		}
+	default:
+		return fmt.Errorf("stathat: unknown stat type %T", stat)
+	}
+
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return fmt.Errorf("stathat: error creating http request: %s", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.Transport.RoundTrip(req)
+	if err != nil {
+		return fmt.Errorf("stathat: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("stathat: classic api error: status %d", resp.StatusCode)
+	} else if c.Debug {
+		log.Printf("stathat: classic api response status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Start the background goroutine for handling the actual HTTP requests.
+func (c *Classic) start() {
+	c.stats = make(chan interface{}, c.ChannelSize)
+	c.closed = make(chan error)
+	if c.Transport == nil {
+		c.Transport = http.DefaultTransport
+	}
+	go c.process()
+}
+
+// Close the background goroutine.
+func (c *Classic) Close() error {
+	close(c.stats)
+	return <-c.closed
+}
+
+// A Flag configured Classic instance.
+func ClassicFlag(name string) *Classic {
+	c := &Classic{StatKeys: map[string]string{}}
+	flag.StringVar(&c.UserKey, name+".user-key", "", name+" stathat user key")
+	flag.BoolVar(&c.Debug, name+".debug", false, name+" debug logging")
+	flag.DurationVar(
+		&c.BatchTimeout,
+		name+".batch-timeout",
+		10*time.Second,
+		name+" amount of time to aggregate a batch",
+	)
+	flag.IntVar(
+		&c.MaxBatchSize,
+		name+".max-batch-size",
+		1000,
+		name+" maximum number of items in a batch",
+	)
+	flag.IntVar(
+		&c.ChannelSize,
+		name+".channel-buffer-size",
+		10000,
+		name+" channel buffer size",
+	)
+	return c
+}
@@ -0,0 +1,121 @@
+package stathat
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roundTripFunc lets a plain function act as an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func okResponse() *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"status":200,"msg":"ok"}`)),
+	}
+}
+
+func newTestEZKey(rt http.RoundTripper) *EZKey {
+	return &EZKey{
+		Key:                  "test",
+		BatchTimeout:         time.Hour, // only flush on explicit Flush
+		MaxBatchSize:         1000,
+		ChannelSize:          10,
+		RetryInitialInterval: time.Millisecond,
+		RetryMaxInterval:     2 * time.Millisecond,
+		RetryMaxElapsed:      20 * time.Millisecond,
+		RetryQueueSize:       10,
+		Transport:            rt,
+	}
+}
+
+func TestFlushSendsImmediately(t *testing.T) {
+	var calls int32
+	e := newTestEZKey(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return okResponse(), nil
+	}))
+	defer e.Close()
+
+	e.Count("some.stat", 1)
+	time.Sleep(10 * time.Millisecond) // let process pick up the queued stat
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := e.Flush(ctx); err != nil {
+		t.Fatalf("Flush returned %v, want nil", err)
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("transport called %d times, want 1", calls)
+	}
+	if batches, _, _ := e.Metrics(); batches != 1 {
+		t.Fatalf("Metrics batches = %d, want 1", batches)
+	}
+}
+
+func TestFlushDoesNotBlockLaterStats(t *testing.T) {
+	e := newTestEZKey(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		time.Sleep(50 * time.Millisecond)
+		return nil, context.DeadlineExceeded
+	}))
+	defer e.Close()
+
+	e.Count("some.stat", 1)
+	time.Sleep(10 * time.Millisecond) // let process pick up the queued stat
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := e.Flush(ctx); err == nil {
+		t.Fatalf("Flush returned nil, want a deadline error")
+	}
+
+	// The flush's own send is still retrying in the background; process
+	// must still be free to accept new stats immediately.
+	done := make(chan struct{})
+	go func() {
+		e.Count("another.stat", 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Count blocked while a flush send was still in flight")
+	}
+}
+
+func TestFlushEnqueuesRetryOnFailure(t *testing.T) {
+	e := newTestEZKey(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, context.DeadlineExceeded
+	}))
+	defer e.Close()
+
+	e.Count("some.stat", 1)
+	time.Sleep(10 * time.Millisecond) // let process pick up the queued stat
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := e.Flush(ctx); err == nil {
+		t.Fatalf("Flush returned nil, want an error since the transport always fails")
+	}
+
+	deadline := time.After(time.Second)
+	for {
+		if _, _, dropped := e.Metrics(); dropped > 0 {
+			t.Fatal("batch was dropped instead of queued for retry")
+		}
+		if batches, retries, _ := e.Metrics(); batches == 0 && retries > 0 {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatal("batch was never retried after the failed flush")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
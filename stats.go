@@ -23,6 +23,14 @@ func SetBackend(b Backend) {
 	backend = b
 }
 
+// Enabled reports whether a backend has been configured via
+// SetBackend. Backend implementations that want to self-report health
+// counters through the stats pipeline should check this before calling
+// Count/Record, since calling either before SetBackend panics.
+func Enabled() bool {
+	return backend != nil
+}
+
 // Record a value.
 func Record(name string, value float64) {
 	if *verbose {